@@ -0,0 +1,154 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the meters, histogram and gauge used to track the outcome and
+// quality of outbound dial attempts, complementing the coarser
+// ingressConnectMeter/egressConnectMeter pair in metrics.go.
+//
+// markDiscovered, markDialStarted and markDialOutcome are the call points
+// this subsystem expects, none of which exist yet since dial.go is not part
+// of this snapshot of the tree:
+//
+//	for candidate := range iterator.Next() { // e.g. v5, dnsdisc, static
+//	    markDiscovered("v5")
+//	    started := markDialStarted()
+//	    err := dialTask.dial(ctx, candidate) // returns a *dialError on failure
+//	    markDialOutcome(err, started)
+//	}
+//
+// Until dial.go wires in calls along those lines, these meters never record
+// anything; the classification logic below is exercised directly by
+// dialmetrics_test.go in the meantime.
+
+package p2p
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// dialOutcome identifies the terminal result of a single dial attempt. It is
+// set on a dialError by whichever stage of the dial (TCP connect, encryption
+// handshake, protocol handshake, post-handshake peer checks) fails, or to
+// dialOutcomeSuccess once the peer is fully added.
+type dialOutcome string
+
+const (
+	dialOutcomeSuccess          dialOutcome = "success"
+	dialOutcomeTCPTimeout       dialOutcome = "failed/tcp_timeout"
+	dialOutcomeTCPRefused       dialOutcome = "failed/tcp_refused"
+	dialOutcomeEncHandshake     dialOutcome = "failed/enc_handshake"
+	dialOutcomeProtoHandshake   dialOutcome = "failed/proto_handshake"
+	dialOutcomeUselessPeer      dialOutcome = "failed/useless_peer"
+	dialOutcomeTooManyPeers     dialOutcome = "failed/too_many_peers"
+	dialOutcomeSelf             dialOutcome = "failed/self"
+	dialOutcomeAlreadyConnected dialOutcome = "failed/already_connected"
+
+	// dialOutcomeUnknown is used for failures that didn't go through
+	// newDialError, so their stage can't be classified. It is deliberately
+	// kept separate from dialOutcomeTCPRefused so that generic errors (e.g.
+	// context cancellation) don't inflate the TCP-refused series.
+	dialOutcomeUnknown dialOutcome = "failed/unknown"
+)
+
+// dialError wraps an error from dialTask.dial together with the dialOutcome
+// it corresponds to, so that callers can both log the underlying error and
+// record a metric for its classification without re-deriving it.
+type dialError struct {
+	outcome dialOutcome
+	err     error
+}
+
+func (e *dialError) Error() string { return e.err.Error() }
+func (e *dialError) Unwrap() error { return e.err }
+
+// newDialError wraps err, classified as outcome, for metrics purposes.
+func newDialError(outcome dialOutcome, err error) *dialError {
+	return &dialError{outcome: outcome, err: err}
+}
+
+// dialOutcomeMeters are the per-outcome dial meters, keyed by dialOutcome.
+var dialOutcomeMeters = map[dialOutcome]metrics.Meter{
+	dialOutcomeSuccess:          metrics.NewRegisteredMeter("p2p/dials/success", nil),
+	dialOutcomeTCPTimeout:       metrics.NewRegisteredMeter("p2p/dials/"+string(dialOutcomeTCPTimeout), nil),
+	dialOutcomeTCPRefused:       metrics.NewRegisteredMeter("p2p/dials/"+string(dialOutcomeTCPRefused), nil),
+	dialOutcomeEncHandshake:     metrics.NewRegisteredMeter("p2p/dials/"+string(dialOutcomeEncHandshake), nil),
+	dialOutcomeProtoHandshake:   metrics.NewRegisteredMeter("p2p/dials/"+string(dialOutcomeProtoHandshake), nil),
+	dialOutcomeUselessPeer:      metrics.NewRegisteredMeter("p2p/dials/"+string(dialOutcomeUselessPeer), nil),
+	dialOutcomeTooManyPeers:     metrics.NewRegisteredMeter("p2p/dials/"+string(dialOutcomeTooManyPeers), nil),
+	dialOutcomeSelf:             metrics.NewRegisteredMeter("p2p/dials/"+string(dialOutcomeSelf), nil),
+	dialOutcomeAlreadyConnected: metrics.NewRegisteredMeter("p2p/dials/"+string(dialOutcomeAlreadyConnected), nil),
+	dialOutcomeUnknown:          metrics.NewRegisteredMeter("p2p/dials/"+string(dialOutcomeUnknown), nil),
+}
+
+var (
+	// dialDurationTimer tracks how long a dial attempt takes, from the moment
+	// the TCP dial starts until its outcome is known.
+	dialDurationTimer = metrics.NewRegisteredTimer("p2p/dials/duration", nil)
+
+	// dialPendingGauge tracks the number of dial attempts currently in flight.
+	dialPendingGauge = metrics.NewRegisteredGauge("p2p/dials/pending", nil)
+)
+
+// discoverySourceMeters count dial candidates handed to the dialer, broken
+// down by the discovery mechanism that produced them.
+var discoverySourceMeters = map[string]metrics.Meter{
+	"v4":      metrics.NewRegisteredMeter("p2p/discovered/v4", nil),
+	"v5":      metrics.NewRegisteredMeter("p2p/discovered/v5", nil),
+	"dnsdisc": metrics.NewRegisteredMeter("p2p/discovered/dnsdisc", nil),
+	"static":  metrics.NewRegisteredMeter("p2p/discovered/static", nil),
+}
+
+// markDiscovered bumps the discovery-source counter for source, which must be
+// one of "v4", "v5", "dnsdisc" or "static". Unknown sources are ignored, so
+// callers don't need to special-case sources added in the future. Called once
+// per dial candidate by each discovery iterator as it's wired in.
+func markDiscovered(source string) {
+	if m, ok := discoverySourceMeters[source]; ok {
+		m.Mark(1)
+	}
+}
+
+// markDialStarted records that a dial attempt is beginning, bumping
+// dialPendingGauge, and returns the start time to later pass to
+// markDialOutcome. Every call must be paired with exactly one markDialOutcome
+// call once the attempt's terminal outcome is known, or the gauge will drift.
+func markDialStarted() time.Time {
+	dialPendingGauge.Inc(1)
+	return time.Now()
+}
+
+// markDialOutcome records the terminal outcome of a dial attempt that was
+// started at the given time, returned by the matching markDialStarted call,
+// and decrements dialPendingGauge back down. err should be nil on success, or
+// a *dialError (or a plain error, which is classified as dialOutcomeUnknown)
+// otherwise.
+func markDialOutcome(err error, started time.Time) {
+	dialPendingGauge.Dec(1)
+
+	outcome := dialOutcomeSuccess
+	if err != nil {
+		outcome = dialOutcomeUnknown
+		if de, ok := err.(*dialError); ok {
+			outcome = de.outcome
+		}
+	}
+	if m, ok := dialOutcomeMeters[outcome]; ok {
+		m.Mark(1)
+	}
+	dialDurationTimer.Update(time.Since(started))
+}
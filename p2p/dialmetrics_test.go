@@ -0,0 +1,86 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMarkDialOutcome(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		outcome dialOutcome
+	}{
+		{"success", nil, dialOutcomeSuccess},
+		{"classified dialError", newDialError(dialOutcomeTooManyPeers, errors.New("too many peers")), dialOutcomeTooManyPeers},
+		{"unclassified error", errors.New("connection reset"), dialOutcomeUnknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := dialOutcomeMeters[test.outcome]
+			before := m.Count()
+			markDialOutcome(test.err, time.Now())
+			if after := m.Count(); after != before+1 {
+				t.Errorf("dialOutcomeMeters[%s] count = %d, want %d", test.outcome, after, before+1)
+			}
+		})
+	}
+}
+
+func TestMarkDialOutcomeUnknownIsNotTCPRefused(t *testing.T) {
+	before := dialOutcomeMeters[dialOutcomeTCPRefused].Count()
+	markDialOutcome(errors.New("generic failure"), time.Now())
+	if after := dialOutcomeMeters[dialOutcomeTCPRefused].Count(); after != before {
+		t.Errorf("an unclassified error bumped dialOutcomeTCPRefused (count %d -> %d), want it to only bump dialOutcomeUnknown", before, after)
+	}
+}
+
+func TestMarkDialStartedAndOutcomeTrackPendingGauge(t *testing.T) {
+	before := dialPendingGauge.Snapshot().Value()
+	started := markDialStarted()
+	if during := dialPendingGauge.Snapshot().Value(); during != before+1 {
+		t.Fatalf("dialPendingGauge after markDialStarted = %d, want %d", during, before+1)
+	}
+	markDialOutcome(nil, started)
+	if after := dialPendingGauge.Snapshot().Value(); after != before {
+		t.Errorf("dialPendingGauge after markDialOutcome = %d, want %d", after, before)
+	}
+}
+
+func TestMarkDiscovered(t *testing.T) {
+	before := discoverySourceMeters["v5"].Count()
+	markDiscovered("v5")
+	if after := discoverySourceMeters["v5"].Count(); after != before+1 {
+		t.Errorf("discoverySourceMeters[v5] count = %d, want %d", after, before+1)
+	}
+
+	// Unknown sources must not panic and must not be silently attributed to
+	// an existing bucket.
+	counts := make(map[string]int64, len(discoverySourceMeters))
+	for source, m := range discoverySourceMeters {
+		counts[source] = m.Count()
+	}
+	markDiscovered("bogus")
+	for source, m := range discoverySourceMeters {
+		if after := m.Count(); after != counts[source] {
+			t.Errorf("markDiscovered(\"bogus\") bumped discoverySourceMeters[%s] (count %d -> %d)", source, counts[source], after)
+		}
+	}
+}
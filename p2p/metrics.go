@@ -20,8 +20,8 @@ package p2p
 
 import (
 	"fmt"
-	"github.com/ethereum/go-ethereum/p2p/enode"
 	"net"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -29,6 +29,7 @@ import (
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 )
 
 const (
@@ -37,6 +38,9 @@ const (
 	MetricsOutboundConnects = "p2p/OutboundConnects" // Name for the registered outbound connects meter
 	MetricsOutboundTraffic  = "p2p/OutboundTraffic"  // Name for the registered outbound traffic meter
 
+	MetricsProtocolInboundTraffic  = "p2p/ProtocolInboundTraffic"  // Name for the registered per-protocol inbound traffic registry
+	MetricsProtocolOutboundTraffic = "p2p/ProtocolOutboundTraffic" // Name for the registered per-protocol outbound traffic registry
+
 	MeteredPeerLimit = 1024 // This amount of peers are individually metered
 )
 
@@ -49,6 +53,15 @@ var (
 	PeerIngressRegistry = metrics.NewPrefixedChildRegistry(metrics.EphemeralRegistry, MetricsInboundTraffic+"/")  // Registry containing the peer ingress
 	PeerEgressRegistry  = metrics.NewPrefixedChildRegistry(metrics.EphemeralRegistry, MetricsOutboundTraffic+"/") // Registry containing the peer egress
 
+	// ProtocolIngressRegistry/ProtocolEgressRegistry hold the per-protocol,
+	// per-message-code meters registered by protocolMeter. They are kept
+	// separate from PeerIngressRegistry/PeerEgressRegistry so that a
+	// "<protocol>/<code>" key (e.g. "eth/5") can never collide with, or be
+	// mistaken for, a "<ip>/<id>" peer key by a consumer iterating those
+	// registries, such as p2p/metrics.Exporter.sampleTraffic.
+	ProtocolIngressRegistry = metrics.NewPrefixedChildRegistry(metrics.EphemeralRegistry, MetricsProtocolInboundTraffic+"/")
+	ProtocolEgressRegistry  = metrics.NewPrefixedChildRegistry(metrics.EphemeralRegistry, MetricsProtocolOutboundTraffic+"/")
+
 	meteredPeerFeed  event.Feed // Event feed for peer metrics
 	meteredPeerCount int32      // Actually stored peer connection count
 )
@@ -79,25 +92,51 @@ const (
 
 	// PeerDisconnected is the type of event emitted when a peer disconnects.
 	PeerDisconnected
+
+	// PeerRateLimited is the type of event emitted when a peer's traffic is
+	// throttled or rejected for exceeding its configured bandwidth quota.
+	PeerRateLimited
+)
+
+// RateLimitScope identifies which bandwidth bucket rejected or throttled
+// traffic on a PeerRateLimited event: the process-wide quota shared by every
+// connection, or the quota specific to that one peer.
+type RateLimitScope string
+
+const (
+	RateLimitScopeGlobal RateLimitScope = "global"
+	RateLimitScopePeer   RateLimitScope = "peer"
 )
 
+// protoTraffic holds the ingress/egress byte counts metered for a single
+// sub-protocol of a peer connection.
+type protoTraffic struct {
+	Ingress, Egress uint64
+}
+
 // MeteredPeerEvent is an event emitted when peers connect or disconnect.
 type MeteredPeerEvent struct {
-	Type      MeteredPeerEventType   // Type of peer event
-	Name      string                 // Name of the node, including client type, version, OS, custom data
-	Addr      string                 // TCP address of the peer
-	Enode     string                 // Node URL
-	ID        enode.ID               // Unique node identifier
-	Protocols map[string]interface{} // Sub-protocol specific metadata fields
-	Elapsed   time.Duration          // Time elapsed between the connection and the handshake/disconnection
-	Ingress   uint64                 // Ingress count at the moment of the event
-	Egress    uint64                 // Egress count at the moment of the event
-	Peer      *Peer                  // Connected remote node instance
+	Type        MeteredPeerEventType    // Type of peer event
+	Name        string                  // Name of the node, including client type, version, OS, custom data
+	Addr        string                  // TCP address of the peer, kept for backwards compatibility; derived from IP/Port
+	IP          net.IP                  // IP address of the peer
+	Port        uint16                  // TCP port of the peer
+	Enode       string                  // Node URL
+	ID          enode.ID                // Unique node identifier
+	Protocols   map[string]interface{}  // Sub-protocol specific metadata fields
+	Elapsed     time.Duration           // Time elapsed between the connection and the handshake/disconnection
+	Ingress     uint64                  // Ingress count at the moment of the event
+	Egress      uint64                  // Egress count at the moment of the event
+	PerProtocol map[string]protoTraffic // Per sub-protocol ingress/egress traffic, keyed by protocol name; empty until protocol handlers are wired through meteredMsgReadWriter
+	Peer        *Peer                   // Connected remote node instance
+
+	RateLimitScope   RateLimitScope // Set on PeerRateLimited events: which bucket rejected or throttled the traffic
+	RateLimitIngress bool           // Set on PeerRateLimited events: whether the affected traffic was ingress or egress
 }
 
 // Equal reports whether event and e are equal.
 func (event *MeteredPeerEvent) Equal(e MeteredPeerEvent) bool {
-	return event.Type == e.Type && event.Addr == e.Addr && event.ID == e.ID && event.Ingress == e.Ingress && event.Egress == e.Egress
+	return event.Type == e.Type && event.IP.Equal(e.IP) && event.Port == e.Port && event.ID == e.ID && event.Ingress == e.Ingress && event.Egress == e.Egress
 }
 
 // SubscribeMeteredPeerEvent registers a subscription for peer life-cycle events
@@ -106,12 +145,33 @@ func SubscribeMeteredPeerEvent(ch chan<- MeteredPeerEvent) event.Subscription {
 	return meteredPeerFeed.Subscribe(ch)
 }
 
+// sendPeerEvent fills in ev.Addr from ev.IP/ev.Port and sends it on
+// meteredPeerFeed. Addr is retained as a plain string field for backwards
+// compatibility with callers constructed before the IP/Port fields existed;
+// routing every send through here keeps it from ever going stale.
+func sendPeerEvent(ev MeteredPeerEvent) {
+	if ev.IP != nil {
+		ev.Addr = net.JoinHostPort(ev.IP.String(), strconv.Itoa(int(ev.Port)))
+	}
+	meteredPeerFeed.Send(ev)
+}
+
+// meterKey derives the canonical registry key used to register and
+// unregister a peer's traffic meters in PeerIngressRegistry/PeerEgressRegistry,
+// from its IP address and node ID. Keying on the structured fields rather
+// than a pre-formatted address string avoids key-mismatch bugs caused by
+// subtly different string representations of the same peer at registration
+// and at Close time.
+func meterKey(ip net.IP, id enode.ID) string {
+	return fmt.Sprintf("%s/%s", ip.String(), id.String())
+}
+
 // meteredConn is a wrapper around a net.Conn that meters both the
 // inbound and outbound network traffic.
 type meteredConn struct {
 	net.Conn // Network connection to wrap with metering
 
-	connected time.Time // Connection time of the peer
+	connected time.Time    // Connection time of the peer
 	addr      *net.TCPAddr // TCP address of the peer
 	id        enode.ID     // NodeID of the peer
 
@@ -124,6 +184,16 @@ type meteredConn struct {
 	ingressMeter   metrics.Meter // Meter for the read bytes of the peer
 	egressMeter    metrics.Meter // Meter for the written bytes of the peer
 
+	// protoTraffic holds the cumulative ingress/egress byte counts of the peer,
+	// broken down by sub-protocol name. Populated by markProtocolTraffic and
+	// reported in the PerProtocol field of the PeerDisconnected event.
+	protoTraffic map[string]*protoTraffic
+
+	// ingressLimiter/egressLimiter enforce this peer's bandwidth quota, set
+	// via setPeerLimits once its capabilities are known. Nil means unlimited.
+	ingressLimiter *tokenBucket
+	egressLimiter  *tokenBucket
+
 	lock sync.RWMutex // Lock protecting the metered connection's internals
 }
 
@@ -147,14 +217,16 @@ func newMeteredConn(conn net.Conn, ingress bool, addr *net.TCPAddr) net.Conn {
 		egressConnectMeter.Mark(1)
 	}
 	return &meteredConn{
-		Conn:      conn,
-		addr:      addr,
-		connected: time.Now(),
+		Conn:         conn,
+		addr:         addr,
+		connected:    time.Now(),
+		protoTraffic: make(map[string]*protoTraffic),
 	}
 }
 
 // Read delegates a network read to the underlying connection, bumping the common
-// and the peer ingress traffic meters along the way.
+// and the peer ingress traffic meters along the way, then enforces the peer's
+// and the global ingress bandwidth quota, if any.
 func (c *meteredConn) Read(b []byte) (n int, err error) {
 	n, err = c.Conn.Read(b)
 	ingressTrafficMeter.Mark(int64(n))
@@ -163,11 +235,17 @@ func (c *meteredConn) Read(b []byte) (n int, err error) {
 		c.ingressMeter.Mark(int64(n))
 	}
 	c.lock.RUnlock()
+	if n > 0 {
+		if lerr := c.throttle(n, true); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
 	return n, err
 }
 
-// Write delegates a network write to the underlying connection, bumping the common
-// and the peer egress traffic meters along the way.
+// Write delegates a network write to the underlying connection, bumping the
+// common and the peer egress traffic meters along the way, then enforces the
+// peer's and the global egress bandwidth quota, if any.
 func (c *meteredConn) Write(b []byte) (n int, err error) {
 	n, err = c.Conn.Write(b)
 	egressTrafficMeter.Mark(int64(n))
@@ -176,9 +254,116 @@ func (c *meteredConn) Write(b []byte) (n int, err error) {
 		c.egressMeter.Mark(int64(n))
 	}
 	c.lock.RUnlock()
+	if n > 0 {
+		if lerr := c.throttle(n, false); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
 	return n, err
 }
 
+// protoMeters caches the per-protocol, per-message-code meters registered in
+// ProtocolIngressRegistry/ProtocolEgressRegistry, keyed by direction, protocol
+// name and message code (e.g. "in/eth/5").
+var (
+	protoMeters   = make(map[string]metrics.Meter)
+	protoMetersMu sync.Mutex
+)
+
+// protocolMeter returns the registered meter tracking traffic of the given
+// direction for protocol/code, creating it on first use.
+func protocolMeter(protocol string, code uint64, ingress bool) metrics.Meter {
+	name := fmt.Sprintf("%s/%d", protocol, code)
+	mapKey := "out/" + name
+	registry := ProtocolEgressRegistry
+	if ingress {
+		mapKey = "in/" + name
+		registry = ProtocolIngressRegistry
+	}
+	protoMetersMu.Lock()
+	defer protoMetersMu.Unlock()
+	if m, ok := protoMeters[mapKey]; ok {
+		return m
+	}
+	m := metrics.NewRegisteredMeter(name, registry)
+	protoMeters[mapKey] = m
+	return m
+}
+
+// markProtocolTraffic bumps the global protocol/code meter and the
+// connection's own per-protocol traffic counters.
+func (c *meteredConn) markProtocolTraffic(protocol string, code uint64, n int, ingress bool) {
+	protocolMeter(protocol, code, ingress).Mark(int64(n))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.protoTraffic[protocol]
+	if t == nil {
+		t = new(protoTraffic)
+		c.protoTraffic[protocol] = t
+	}
+	if ingress {
+		t.Ingress += uint64(n)
+	} else {
+		t.Egress += uint64(n)
+	}
+}
+
+// protocolTraffic returns a snapshot of the connection's per-protocol traffic
+// counters, or nil if no sub-protocol traffic has been metered yet.
+func (c *meteredConn) protocolTraffic() map[string]protoTraffic {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if len(c.protoTraffic) == 0 {
+		return nil
+	}
+	snapshot := make(map[string]protoTraffic, len(c.protoTraffic))
+	for protocol, t := range c.protoTraffic {
+		snapshot[protocol] = *t
+	}
+	return snapshot
+}
+
+// meteredMsgReadWriter wraps a MsgReadWriter so that every message read or
+// written through it is additionally metered under conn, broken down by
+// protocol name and message code. Its single intended call site is
+// Peer.startProtocols in peer.go, which is not part of this snapshot of the
+// tree: for each Protocol it starts, it would wrap that protocol's
+// MsgReadWriter with newMeteredMsgReadWriter(rw, conn, proto.Name) before
+// handing it to Protocol.Run, where conn is the peer's underlying
+// *meteredConn. Until that call site exists, newMeteredMsgReadWriter is never
+// invoked and PerProtocol on MeteredPeerEvent stays empty.
+type meteredMsgReadWriter struct {
+	MsgReadWriter
+	conn     *meteredConn
+	protocol string
+}
+
+// newMeteredMsgReadWriter wraps rw for protocol so that its traffic is
+// accounted for on conn. If metrics are disabled, rw is returned unwrapped.
+func newMeteredMsgReadWriter(rw MsgReadWriter, conn *meteredConn, protocol string) MsgReadWriter {
+	if !metrics.Enabled {
+		return rw
+	}
+	return &meteredMsgReadWriter{MsgReadWriter: rw, conn: conn, protocol: protocol}
+}
+
+func (rw *meteredMsgReadWriter) ReadMsg() (Msg, error) {
+	msg, err := rw.MsgReadWriter.ReadMsg()
+	if err == nil {
+		rw.conn.markProtocolTraffic(rw.protocol, msg.Code, int(msg.Size), true)
+	}
+	return msg, err
+}
+
+func (rw *meteredMsgReadWriter) WriteMsg(msg Msg) error {
+	err := rw.MsgReadWriter.WriteMsg(msg)
+	if err == nil {
+		rw.conn.markProtocolTraffic(rw.protocol, msg.Code, int(msg.Size), false)
+	}
+	return err
+}
+
 // encHandshakeDone is called after the connection passes the encryption
 // handshake. Registers the peer to the ingress and the egress traffic
 // registries using the peer's IP and node ID, also emits connect event.
@@ -186,9 +371,10 @@ func (c *meteredConn) encHandshakeDone(id enode.ID) {
 	c.lock.Lock()
 	c.id = id
 	c.lock.Unlock()
-	meteredPeerFeed.Send(MeteredPeerEvent{
+	sendPeerEvent(MeteredPeerEvent{
 		Type:    PeerEncHandshakeSucceeded,
-		Addr:    c.addr.String(),
+		IP:      c.addr.IP,
+		Port:    uint16(c.addr.Port),
 		ID:      id,
 		Elapsed: time.Since(c.connected),
 	})
@@ -207,15 +393,16 @@ func (c *meteredConn) peerAdded(peer *Peer) {
 	} else {
 		c.lock.Lock()
 		id, c.trafficMetered = c.id, true
-		key := fmt.Sprintf("%s/%s", c.addr.String(), id.String())
+		key := meterKey(c.addr.IP, id)
 		c.ingressMeter = metrics.NewRegisteredMeter(key, PeerIngressRegistry)
 		c.egressMeter = metrics.NewRegisteredMeter(key, PeerEgressRegistry)
 		c.lock.Unlock()
 	}
 	info := peer.Info()
-	meteredPeerFeed.Send(MeteredPeerEvent{
+	sendPeerEvent(MeteredPeerEvent{
 		Type:  PeerProtoHandshakeSucceeded,
-		Addr:  c.addr.String(),
+		IP:    c.addr.IP,
+		Port:  uint16(c.addr.Port),
 		ID:    id,
 		Enode: info.Enode,
 		Name:  info.Name,
@@ -229,9 +416,10 @@ func (c *meteredConn) peerMessageHandlingStarted(protocols map[string]interface{
 	c.lock.Lock()
 	id := c.id
 	c.lock.Unlock()
-	meteredPeerFeed.Send(MeteredPeerEvent{
+	sendPeerEvent(MeteredPeerEvent{
 		Type:      PeerMessageHandlingStarted,
-		Addr:      c.addr.String(),
+		IP:        c.addr.IP,
+		Port:      uint16(c.addr.Port),
 		ID:        id,
 		Protocols: protocols,
 	})
@@ -245,9 +433,10 @@ func (c *meteredConn) Close() error {
 	if c.id == (enode.ID{}) {
 		// If the peer disconnects before/during the encryption handshake.
 		c.lock.RUnlock()
-		meteredPeerFeed.Send(MeteredPeerEvent{
+		sendPeerEvent(MeteredPeerEvent{
 			Type:    PeerEncHandshakeFailed,
-			Addr:    c.addr.String(),
+			IP:      c.addr.IP,
+			Port:    uint16(c.addr.Port),
 			Elapsed: time.Since(c.connected),
 		})
 		return err
@@ -257,10 +446,11 @@ func (c *meteredConn) Close() error {
 		// If the peer disconnects before/during the protocol handshake,
 		// or it isn't registered in the traffic registries.
 		c.lock.RUnlock()
-		meteredPeerFeed.Send(MeteredPeerEvent{
-			Type:    PeerProtoHandshakeFailed,
-			Addr:    c.addr.String(),
-			ID:      id,
+		sendPeerEvent(MeteredPeerEvent{
+			Type: PeerProtoHandshakeFailed,
+			IP:   c.addr.IP,
+			Port: uint16(c.addr.Port),
+			ID:   id,
 		})
 		return err
 	}
@@ -271,16 +461,18 @@ func (c *meteredConn) Close() error {
 	atomic.AddInt32(&meteredPeerCount, -1)
 
 	// Unregister the peer from the traffic registries
-	key := fmt.Sprintf("%s/%s", c.addr.String(), id)
+	key := meterKey(c.addr.IP, id)
 	PeerIngressRegistry.Unregister(key)
 	PeerEgressRegistry.Unregister(key)
 
-	meteredPeerFeed.Send(MeteredPeerEvent{
-		Type:    PeerDisconnected,
-		Addr:    c.addr.String(),
-		ID:      id,
-		Ingress: ingress,
-		Egress:  egress,
+	sendPeerEvent(MeteredPeerEvent{
+		Type:        PeerDisconnected,
+		IP:          c.addr.IP,
+		Port:        uint16(c.addr.Port),
+		ID:          id,
+		Ingress:     ingress,
+		Egress:      egress,
+		PerProtocol: c.protocolTraffic(),
 	})
 	return err
 }
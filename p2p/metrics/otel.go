@@ -0,0 +1,91 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains a push-based PeerMetricsSink that reports peer metrics through an
+// OpenTelemetry Meter, for export over OTLP.
+
+package metrics
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// OTelSink is a PeerMetricsSink that records peer metrics on an
+// OpenTelemetry Meter. Like PrometheusSink, per-peer attributes are limited
+// to the node ID. Only peers among the TopNPeers of a sample get their own
+// attribute set, but no attribute set is ever removed, so the number of
+// series grows with every peer that has ever been reported.
+type OTelSink struct {
+	events  metric.Int64Counter
+	ingress metric.Int64UpDownCounter
+	egress  metric.Int64UpDownCounter
+
+	// last holds the previously reported ingress/egress counts per peer, so
+	// that OnTraffic's cumulative samples can be converted into the deltas
+	// metric.Int64UpDownCounter.Add expects.
+	last map[enode.ID][2]uint64
+}
+
+// NewOTelSink creates an OTelSink that instruments meter, which callers
+// obtain from their chosen OTLP exporter's MeterProvider.
+func NewOTelSink(meter metric.Meter) (*OTelSink, error) {
+	events, err := meter.Int64Counter("p2p.peer_events",
+		metric.WithDescription("Number of peer life-cycle events, by event type."))
+	if err != nil {
+		return nil, err
+	}
+	ingress, err := meter.Int64UpDownCounter("p2p.peer_ingress_bytes",
+		metric.WithDescription("Cumulative ingress bytes, by peer node ID."))
+	if err != nil {
+		return nil, err
+	}
+	egress, err := meter.Int64UpDownCounter("p2p.peer_egress_bytes",
+		metric.WithDescription("Cumulative egress bytes, by peer node ID."))
+	if err != nil {
+		return nil, err
+	}
+	return &OTelSink{
+		events:  events,
+		ingress: ingress,
+		egress:  egress,
+		last:    make(map[enode.ID][2]uint64),
+	}, nil
+}
+
+// OnEvent implements PeerMetricsSink.
+func (s *OTelSink) OnEvent(ev p2p.MeteredPeerEvent) {
+	s.events.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("type", eventTypeLabel(ev.Type)),
+	))
+	if ev.Type == p2p.PeerDisconnected {
+		delete(s.last, ev.ID)
+	}
+}
+
+// OnTraffic implements PeerMetricsSink.
+func (s *OTelSink) OnTraffic(id enode.ID, ingress, egress uint64) {
+	attrs := metric.WithAttributes(attribute.String("id", id.String()))
+
+	prev := s.last[id]
+	s.ingress.Add(context.Background(), int64(ingress-prev[0]), attrs)
+	s.egress.Add(context.Background(), int64(egress-prev[1]), attrs)
+	s.last[id] = [2]uint64{ingress, egress}
+}
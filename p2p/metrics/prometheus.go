@@ -0,0 +1,106 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains a pull-based PeerMetricsSink that exposes peer metrics as
+// Prometheus series.
+
+package metrics
+
+import (
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusSink is a PeerMetricsSink that keeps peer metrics as Prometheus
+// collectors, scraped by a Prometheus server. Per-peer labels are limited to
+// the node ID, so client name or remote address churn adds no series. A
+// peer's series is created the first time it is among the TopNPeers of a
+// sample and is deleted only when the peer disconnects, so the number of
+// series is bounded by the connected peers that have ever been in the top
+// TopNPeers, not by TopNPeers itself.
+type PrometheusSink struct {
+	events  *prometheus.CounterVec
+	ingress *prometheus.GaugeVec
+	egress  *prometheus.GaugeVec
+}
+
+// NewPrometheusSink creates a PrometheusSink and registers its collectors
+// with reg. If reg is nil, the default Prometheus registerer is used.
+func NewPrometheusSink(reg prometheus.Registerer) *PrometheusSink {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	s := &PrometheusSink{
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "p2p",
+			Name:      "peer_events_total",
+			Help:      "Number of peer life-cycle events, by event type.",
+		}, []string{"type"}),
+		ingress: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Name:      "peer_ingress_bytes",
+			Help:      "Cumulative ingress bytes, by peer node ID.",
+		}, []string{"id"}),
+		egress: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "p2p",
+			Name:      "peer_egress_bytes",
+			Help:      "Cumulative egress bytes, by peer node ID.",
+		}, []string{"id"}),
+	}
+	reg.MustRegister(s.events, s.ingress, s.egress)
+	return s
+}
+
+// OnEvent implements PeerMetricsSink. Disconnect events additionally delete
+// the peer's traffic gauges. Nothing else deletes them: a peer that drops out
+// of the top TopNPeers keeps its last reported values until it disconnects.
+func (s *PrometheusSink) OnEvent(ev p2p.MeteredPeerEvent) {
+	s.events.WithLabelValues(eventTypeLabel(ev.Type)).Inc()
+	if ev.Type == p2p.PeerDisconnected {
+		s.ingress.DeleteLabelValues(ev.ID.String())
+		s.egress.DeleteLabelValues(ev.ID.String())
+	}
+}
+
+// OnTraffic implements PeerMetricsSink.
+func (s *PrometheusSink) OnTraffic(id enode.ID, ingress, egress uint64) {
+	label := id.String()
+	s.ingress.WithLabelValues(label).Set(float64(ingress))
+	s.egress.WithLabelValues(label).Set(float64(egress))
+}
+
+// eventTypeLabel returns the Prometheus label value for a peer event type.
+func eventTypeLabel(t p2p.MeteredPeerEventType) string {
+	switch t {
+	case p2p.PeerEncHandshakeSucceeded:
+		return "enc_handshake_succeeded"
+	case p2p.PeerEncHandshakeFailed:
+		return "enc_handshake_failed"
+	case p2p.PeerProtoHandshakeSucceeded:
+		return "proto_handshake_succeeded"
+	case p2p.PeerProtoHandshakeFailed:
+		return "proto_handshake_failed"
+	case p2p.PeerMessageHandlingStarted:
+		return "message_handling_started"
+	case p2p.PeerDisconnected:
+		return "disconnected"
+	case p2p.PeerRateLimited:
+		return "rate_limited"
+	default:
+		return "unknown"
+	}
+}
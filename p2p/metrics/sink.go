@@ -0,0 +1,180 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package metrics adapts the peer life-cycle and traffic events emitted by
+// package p2p onto pluggable observability backends, as an alternative to
+// reading them back out of the metrics.EphemeralRegistry-based registries in
+// p2p.PeerIngressRegistry/PeerEgressRegistry.
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// PeerMetricsSink receives peer life-cycle and traffic events forwarded by an
+// Exporter, translating them into whatever a concrete observability backend
+// expects.
+type PeerMetricsSink interface {
+	// OnEvent is called for every peer life-cycle event emitted by p2p
+	// (handshake succeeded/failed, message handling started, disconnected,
+	// rate limited).
+	OnEvent(event p2p.MeteredPeerEvent)
+
+	// OnTraffic is called on every sampling tick with the cumulative
+	// ingress/egress byte counts of a peer, or of the aggregated tail bucket
+	// (identified by AggregateID) once the top-N cardinality cap is exceeded.
+	OnTraffic(id enode.ID, ingress, egress uint64)
+}
+
+// TopNPeers is the number of highest-traffic peers reported to a sink under
+// their own node ID; the remaining peers are folded into a single bucket
+// identified by AggregateID, so per-peer labels can't explode past
+// p2p.MeteredPeerLimit on a busy node.
+const TopNPeers = 64
+
+// AggregateID is the synthetic node ID passed to PeerMetricsSink.OnTraffic
+// for the traffic aggregated across peers outside the top TopNPeers.
+var AggregateID = enode.ID{0xff}
+
+// Exporter subscribes to p2p.SubscribeMeteredPeerEvent, periodically samples
+// the per-peer traffic registries, and forwards both to a PeerMetricsSink
+// after applying the TopNPeers cardinality cap.
+type Exporter struct {
+	sink   PeerMetricsSink
+	period time.Duration
+
+	eventCh  chan p2p.MeteredPeerEvent
+	eventSub event.Subscription
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewExporter creates an Exporter that forwards events to sink and samples
+// traffic registries every period.
+func NewExporter(sink PeerMetricsSink, period time.Duration) *Exporter {
+	return &Exporter{
+		sink:    sink,
+		period:  period,
+		eventCh: make(chan p2p.MeteredPeerEvent, 256),
+		quit:    make(chan struct{}),
+	}
+}
+
+// Start begins forwarding events and sampling traffic until Stop is called.
+func (e *Exporter) Start() {
+	e.eventSub = p2p.SubscribeMeteredPeerEvent(e.eventCh)
+	e.wg.Add(1)
+	go e.loop()
+}
+
+// Stop terminates the exporter and waits for its goroutine to exit.
+func (e *Exporter) Stop() {
+	e.eventSub.Unsubscribe()
+	close(e.quit)
+	e.wg.Wait()
+}
+
+func (e *Exporter) loop() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev := <-e.eventCh:
+			e.sink.OnEvent(ev)
+		case <-ticker.C:
+			e.sampleTraffic()
+		case <-e.eventSub.Err():
+			return
+		case <-e.quit:
+			return
+		}
+	}
+}
+
+// sampleTraffic reads the current per-peer meters out of
+// p2p.PeerIngressRegistry/PeerEgressRegistry, ranks peers by total traffic
+// and reports the top TopNPeers individually, folding the rest into
+// AggregateID.
+func (e *Exporter) sampleTraffic() {
+	ingress := make(map[enode.ID]uint64)
+	egress := make(map[enode.ID]uint64)
+
+	p2p.PeerIngressRegistry.Each(func(name string, i interface{}) {
+		if m, ok := i.(metrics.Meter); ok {
+			if id, ok := peerIDFromMeterKey(name); ok {
+				ingress[id] = uint64(m.Count())
+			}
+		}
+	})
+	p2p.PeerEgressRegistry.Each(func(name string, i interface{}) {
+		if m, ok := i.(metrics.Meter); ok {
+			if id, ok := peerIDFromMeterKey(name); ok {
+				egress[id] = uint64(m.Count())
+			}
+		}
+	})
+
+	type peerTraffic struct {
+		id              enode.ID
+		ingress, egress uint64
+	}
+	peers := make([]peerTraffic, 0, len(ingress))
+	for id, in := range ingress {
+		peers = append(peers, peerTraffic{id: id, ingress: in, egress: egress[id]})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ingress+peers[i].egress > peers[j].ingress+peers[j].egress
+	})
+
+	var aggIngress, aggEgress uint64
+	for i, p := range peers {
+		if i < TopNPeers {
+			e.sink.OnTraffic(p.id, p.ingress, p.egress)
+		} else {
+			aggIngress += p.ingress
+			aggEgress += p.egress
+		}
+	}
+	if aggIngress > 0 || aggEgress > 0 {
+		e.sink.OnTraffic(AggregateID, aggIngress, aggEgress)
+	}
+}
+
+// peerIDFromMeterKey recovers the node ID suffix from a key of the form
+// "<ip>/<id>", as produced by p2p's internal meterKey helper.
+func peerIDFromMeterKey(key string) (enode.ID, bool) {
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return enode.ID{}, false
+	}
+	var id enode.ID
+	if err := id.UnmarshalText([]byte(key[i+1:])); err != nil {
+		return enode.ID{}, false
+	}
+	return id, true
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import "testing"
+
+func TestMeteredConnProtocolTraffic(t *testing.T) {
+	c := &meteredConn{protoTraffic: make(map[string]*protoTraffic)}
+
+	c.markProtocolTraffic("eth", 5, 100, true) // GetBlockBodies ingress
+	c.markProtocolTraffic("eth", 5, 40, false) // GetBlockBodies egress
+	c.markProtocolTraffic("snap", 1, 7, true)  // GetAccountRange ingress
+	c.markProtocolTraffic("eth", 5, 25, true)  // more GetBlockBodies ingress
+
+	got := c.protocolTraffic()
+	want := map[string]protoTraffic{
+		"eth":  {Ingress: 125, Egress: 40},
+		"snap": {Ingress: 7, Egress: 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("protocolTraffic() = %+v, want %+v", got, want)
+	}
+	for proto, traffic := range want {
+		if got[proto] != traffic {
+			t.Errorf("protocolTraffic()[%q] = %+v, want %+v", proto, got[proto], traffic)
+		}
+	}
+}
+
+func TestMeteredConnProtocolTrafficEmpty(t *testing.T) {
+	c := &meteredConn{protoTraffic: make(map[string]*protoTraffic)}
+	if got := c.protocolTraffic(); got != nil {
+		t.Errorf("protocolTraffic() on a connection with no sub-protocol traffic = %+v, want nil", got)
+	}
+}
+
+func TestProtocolMeterCaching(t *testing.T) {
+	in := protocolMeter("eth", 5, true)
+	if again := protocolMeter("eth", 5, true); again != in {
+		t.Error("protocolMeter returned a different meter for the same protocol/code/direction on the second call")
+	}
+	out := protocolMeter("eth", 5, false)
+	if out == in {
+		t.Error("protocolMeter returned the same meter for the ingress and egress directions of the same protocol/code")
+	}
+}
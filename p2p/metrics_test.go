@@ -0,0 +1,56 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestMeterKey(t *testing.T) {
+	ip := net.ParseIP("203.0.113.7")
+	var id enode.ID
+	id[0] = 0xab
+
+	key := meterKey(ip, id)
+	if want := ip.String() + "/" + id.String(); key != want {
+		t.Errorf("meterKey(%v, %v) = %q, want %q", ip, id, key, want)
+	}
+	if again := meterKey(ip, id); again != key {
+		t.Errorf("meterKey is not deterministic: got %q then %q", key, again)
+	}
+}
+
+func TestSendPeerEventDerivesAddr(t *testing.T) {
+	ch := make(chan MeteredPeerEvent, 1)
+	sub := SubscribeMeteredPeerEvent(ch)
+	defer sub.Unsubscribe()
+
+	ip := net.ParseIP("203.0.113.7")
+	sendPeerEvent(MeteredPeerEvent{Type: PeerDisconnected, IP: ip, Port: 30303})
+
+	select {
+	case ev := <-ch:
+		if want := net.JoinHostPort(ip.String(), "30303"); ev.Addr != want {
+			t.Errorf("ev.Addr = %q, want %q", ev.Addr, want)
+		}
+	default:
+		t.Fatal("sendPeerEvent did not deliver an event to the subscriber")
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Contains the token-bucket primitives meteredConn.Read/Write call through
+// throttle to turn metering into enforcement.
+//
+// This file implements the enforcement mechanism only; it is not yet wired
+// into a working feature, since its two call sites are not part of this
+// snapshot of the tree:
+//
+//	// Server.Start, reading new Config fields not part of this snapshot:
+//	SetGlobalBandwidthLimits(cfg.TotalIngressLimit, cfg.TotalEgressLimit)
+//
+//	// Server.addPeer-equivalent setup, once a peer's capabilities are known:
+//	in, out := cfg.PeerLimitFunc(peer)
+//	conn.setPeerLimits(in, out)
+//
+// Neither p2p.Config (which would gain PeerIngressLimit, PeerEgressLimit,
+// TotalIngressLimit, TotalEgressLimit and PeerLimitFunc fields) nor the
+// DiscBandwidthExceeded disconnect reason a caller would use on sustained
+// violation (which belongs in peer_error.go) exist yet. Until that wiring
+// lands, every tokenBucket stays nil and throttle is a no-op; see
+// ratelimit_test.go for coverage of the bucket math itself.
+
+package p2p
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBandwidthExceeded is returned by meteredConn.Read/Write when the
+// process-wide bandwidth quota set by SetGlobalBandwidthLimits is exceeded.
+// Traffic over a peer's own quota blocks instead of returning it.
+var ErrBandwidthExceeded = errors.New("bandwidth limit exceeded")
+
+// PeerLimitFunc returns the per-peer ingress/egress bandwidth limits, in
+// bytes/sec, that should apply to peer. A returned value of 0 leaves that
+// direction subject only to the global Total*Limit, if any.
+type PeerLimitFunc func(peer *Peer) (ingress, egress int64)
+
+// tokenBucket is a simple token-bucket rate limiter enforcing a bytes/sec
+// quota. A nil *tokenBucket is unlimited.
+type tokenBucket struct {
+	rate  int64 // bytes/sec
+	burst int64 // maximum accumulated tokens
+
+	mu       sync.Mutex
+	tokens   int64
+	lastFill time.Time
+}
+
+// newTokenBucket creates a tokenBucket enforcing rate bytes/sec, or returns
+// nil if rate is not positive (i.e. unlimited).
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, burst: rate, tokens: rate, lastFill: time.Now()}
+}
+
+// refill tops up the bucket based on time elapsed since the last refill. The
+// caller must hold b.mu.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.lastFill); elapsed > 0 {
+		b.tokens += int64(elapsed.Seconds() * float64(b.rate))
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.lastFill = now
+	}
+}
+
+// take accounts for n bytes of traffic, blocking until enough tokens have
+// accumulated. It returns ErrBandwidthExceeded instead of blocking if reject
+// is set and the bucket holds fewer tokens than the request needs. Requests larger than the bucket's
+// burst size are capped to it, so a single oversized read/write can't block
+// forever waiting for more tokens than the bucket is able to hold.
+func (b *tokenBucket) take(n int64, reject bool) error {
+	if b == nil {
+		return nil
+	}
+	need := n
+	if need > b.burst {
+		need = b.burst
+	}
+	for {
+		b.mu.Lock()
+		b.refill(time.Now())
+		if b.tokens >= need {
+			b.tokens -= need
+			b.mu.Unlock()
+			return nil
+		}
+		if reject {
+			b.mu.Unlock()
+			return ErrBandwidthExceeded
+		}
+		wait := time.Duration(float64(need-b.tokens) / float64(b.rate) * float64(time.Second))
+		b.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// Global bandwidth buckets, shared by every metered connection. They are
+// nil (unlimited) until SetGlobalBandwidthLimits is called; nothing in this
+// package calls it.
+var (
+	globalIngressBucket *tokenBucket
+	globalEgressBucket  *tokenBucket
+	globalBucketLock    sync.RWMutex
+)
+
+// SetGlobalBandwidthLimits configures the process-wide ingress/egress
+// bandwidth quotas shared by all metered connections, in bytes/sec. A value
+// of 0 removes that direction's limit.
+func SetGlobalBandwidthLimits(ingress, egress int64) {
+	globalBucketLock.Lock()
+	defer globalBucketLock.Unlock()
+	globalIngressBucket = newTokenBucket(ingress)
+	globalEgressBucket = newTokenBucket(egress)
+}
+
+func globalBuckets() (ingress, egress *tokenBucket) {
+	globalBucketLock.RLock()
+	defer globalBucketLock.RUnlock()
+	return globalIngressBucket, globalEgressBucket
+}
+
+// setPeerLimits installs the per-peer token buckets on c, with ingress and
+// egress as their rates in bytes/sec; a rate that is not positive leaves that
+// direction unlimited. It is called once the peer's capabilities are known,
+// typically from the PeerLimitFunc configured on the Server.
+func (c *meteredConn) setPeerLimits(ingress, egress int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ingressLimiter = newTokenBucket(ingress)
+	c.egressLimiter = newTokenBucket(egress)
+}
+
+// throttle applies both the global and the peer-specific bandwidth quota for
+// n bytes of traffic in the given direction. The global bucket is checked
+// first and rejects rather than blocks, so that a single abusive peer can't
+// stall the reads/writes of unrelated connections waiting on it; checking it
+// before the blocking peer-specific bucket also avoids permanently spending
+// the peer's own tokens on traffic the global quota ends up rejecting. The
+// peer-specific bucket is then probed the same way before it's allowed to
+// block, so that a peer tripping only its own quota (global quota not
+// exceeded) still gets a PeerRateLimited event to key a disconnect decision
+// off of, instead of just blocking silently.
+func (c *meteredConn) throttle(n int, ingress bool) error {
+	globalIn, globalOut := globalBuckets()
+	global := globalOut
+	if ingress {
+		global = globalIn
+	}
+	if err := global.take(int64(n), true); err != nil {
+		c.reportRateLimited(ingress, RateLimitScopeGlobal)
+		return err
+	}
+
+	c.lock.RLock()
+	limiter := c.egressLimiter
+	if ingress {
+		limiter = c.ingressLimiter
+	}
+	c.lock.RUnlock()
+
+	if err := limiter.take(int64(n), true); err != nil {
+		c.reportRateLimited(ingress, RateLimitScopePeer)
+		return limiter.take(int64(n), false)
+	}
+	return nil
+}
+
+// reportRateLimited emits a PeerRateLimited event for the peer, identifying
+// which bucket (global or peer-specific) and direction were affected. On
+// sustained violations the caller may choose to additionally Close the
+// connection; doing so surfaces as a PeerDisconnected event, since this
+// snapshot of the tree predates the dedicated DiscBandwidthExceeded
+// disconnect reason that would otherwise be threaded through peer_error.go.
+func (c *meteredConn) reportRateLimited(ingress bool, scope RateLimitScope) {
+	c.lock.RLock()
+	id := c.id
+	addr := c.addr
+	c.lock.RUnlock()
+
+	sendPeerEvent(MeteredPeerEvent{
+		Type:             PeerRateLimited,
+		IP:               addr.IP,
+		Port:             uint16(addr.Port),
+		ID:               id,
+		RateLimitScope:   scope,
+		RateLimitIngress: ingress,
+	})
+}
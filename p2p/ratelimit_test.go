@@ -0,0 +1,106 @@
+// Copyright 2023 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketNilIsUnlimited(t *testing.T) {
+	var b *tokenBucket
+	if err := b.take(1<<40, true); err != nil {
+		t.Errorf("nil *tokenBucket.take returned %v, want nil (unlimited)", err)
+	}
+}
+
+func TestTokenBucketRejectsWhenEmpty(t *testing.T) {
+	b := newTokenBucket(100)
+	if err := b.take(100, true); err != nil {
+		t.Fatalf("first take(100) failed: %v", err)
+	}
+	if err := b.take(1, true); err != ErrBandwidthExceeded {
+		t.Errorf("take() on an empty bucket = %v, want ErrBandwidthExceeded", err)
+	}
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	b := newTokenBucket(100)
+	if err := b.take(100, true); err != nil {
+		t.Fatalf("first take(100) failed: %v", err)
+	}
+	b.lastFill = b.lastFill.Add(-time.Second) // simulate a full second of elapsed time
+	if err := b.take(100, true); err != nil {
+		t.Errorf("take(100) after a simulated refill interval = %v, want nil", err)
+	}
+}
+
+func TestTokenBucketCapsOversizedRequestToBurst(t *testing.T) {
+	b := newTokenBucket(100) // burst == rate == 100
+	done := make(chan error, 1)
+	go func() { done <- b.take(10_000, false) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("take() for a request larger than burst = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("take() for a request larger than burst blocked forever instead of being capped to the bucket's burst size")
+	}
+}
+
+func TestThrottleReportsPeerScopeWhenOnlyPeerQuotaExceeded(t *testing.T) {
+	ch := make(chan MeteredPeerEvent, 1)
+	sub := SubscribeMeteredPeerEvent(ch)
+	defer sub.Unsubscribe()
+
+	c := &meteredConn{addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 30303}}
+	c.id[0] = 0xcd
+	c.setPeerLimits(100, 0) // ingress limited to 100 bytes/sec, no global limit set
+
+	if err := c.throttle(100, true); err != nil {
+		t.Fatalf("first throttle(100) failed: %v", err)
+	}
+	select {
+	case <-ch:
+		t.Fatal("throttle() under quota emitted an unexpected PeerRateLimited event")
+	default:
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.throttle(1, true) }()
+
+	select {
+	case ev := <-ch:
+		if ev.Type != PeerRateLimited {
+			t.Errorf("event.Type = %v, want PeerRateLimited", ev.Type)
+		}
+		if ev.RateLimitScope != RateLimitScopePeer {
+			t.Errorf("event.RateLimitScope = %q, want %q", ev.RateLimitScope, RateLimitScopePeer)
+		}
+		if !ev.RateLimitIngress {
+			t.Error("event.RateLimitIngress = false, want true")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("throttle() exceeding only the peer quota never emitted a PeerRateLimited event")
+	}
+	if err := <-done; err != nil {
+		t.Errorf("throttle() over the peer quota = %v, want nil (it should block, not reject)", err)
+	}
+}